@@ -0,0 +1,133 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"math/rand"
+
+	"github.com/syncthing/syncthing/internal/protocol"
+)
+
+// ChunkingMode selects how a file's content is split into blocks.
+type ChunkingMode int
+
+const (
+	// FixedSize splits files into BlockSize-sized blocks, as Blocks() does.
+	FixedSize ChunkingMode = iota
+	// ContentDefined uses a FastCDC-style rolling hash to place chunk
+	// boundaries based on content, so edits in the middle of a file don't
+	// shift every block after them.
+	ContentDefined
+)
+
+// FlagContentDefinedChunks marks a FileInfo whose Blocks were produced by
+// the content-defined chunker rather than fixed-size blocking, so peers
+// know to expect variable-size blocks for it instead of treating a short
+// final block as truncation.
+const FlagContentDefinedChunks uint32 = 1 << 19
+
+// gearTable is the per-byte multiplier used by the rolling hash in
+// cdcChunker. It is derived from a fixed seed at init time rather than
+// hardcoded so that every node computes the identical table: the values
+// themselves are arbitrary, but two peers must agree on them to agree on
+// chunk boundaries.
+var gearTable [256]uint64
+
+func init() {
+	rnd := rand.New(rand.NewSource(0x63646367)) // "cdcg", arbitrary but fixed
+	for i := range gearTable {
+		gearTable[i] = rnd.Uint64()
+	}
+}
+
+// cdcChunker splits a byte stream into content-defined chunks.
+type cdcChunker struct {
+	minSize, maxSize int
+	mask             uint64
+}
+
+// newCDCChunker returns a chunker targeting an average chunk size of
+// blockSize, bounded to [blockSize/4, blockSize*8].
+func newCDCChunker(blockSize int) cdcChunker {
+	return cdcChunker{
+		minSize: blockSize / 4,
+		maxSize: blockSize * 8,
+		mask:    cdcMask(blockSize),
+	}
+}
+
+// cdcMask returns a mask with roughly log2(avgSize) low bits set, so that a
+// uniformly distributed rolling hash satisfies hash&mask == 0 on average
+// once every avgSize bytes. bits.Len(avgSize) overcounts by one -- it's
+// floor(log2(avgSize))+1 -- so that's subtracted back off before building
+// the mask; leaving it in doubles the expected gap between cut points.
+func cdcMask(avgSize int) uint64 {
+	n := bits.Len(uint(avgSize)) - 1
+	if n <= 0 {
+		return 0
+	}
+	return uint64(1)<<uint(n) - 1
+}
+
+// Chunks reads r to exhaustion and returns a BlockInfo per content-defined
+// chunk, with Offset counted from startOffset. Each chunk's Hash is its
+// SHA-256.
+func (c cdcChunker) Chunks(r io.Reader, startOffset int64) ([]protocol.BlockInfo, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	chunk := make([]byte, 0, c.maxSize)
+	var blocks []protocol.BlockInfo
+	offset := startOffset
+	var hash uint64
+
+	flush := func() {
+		h := sha256.Sum256(chunk)
+		blocks = append(blocks, protocol.BlockInfo{
+			Offset: offset,
+			Size:   int32(len(chunk)),
+			Hash:   h[:],
+		})
+		offset += int64(len(chunk))
+		chunk = chunk[:0]
+		hash = 0
+	}
+
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk = append(chunk, b)
+		hash = (hash << 1) + gearTable[b]
+
+		if len(chunk) >= c.maxSize || (len(chunk) >= c.minSize && hash&c.mask == 0) {
+			flush()
+		}
+	}
+
+	if len(chunk) > 0 {
+		flush()
+	}
+
+	return blocks, nil
+}
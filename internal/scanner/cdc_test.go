@@ -0,0 +1,167 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// randomContent returns n deterministic pseudo-random bytes, so tests can
+// reuse the same content without depending on the real content-defined cut
+// points drifting between runs.
+func randomContent(n int) []byte {
+	buf := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(buf)
+	return buf
+}
+
+func TestCDCChunkerCoversWholeInput(t *testing.T) {
+	data := randomContent(200 * 1024)
+
+	blocks, err := newCDCChunker(8 * 1024).Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	if len(blocks) < 2 {
+		t.Fatalf("got %d blocks for %d bytes, expected more than one", len(blocks), len(data))
+	}
+
+	var total int64
+	for i, b := range blocks {
+		if b.Offset != total {
+			t.Fatalf("block %d offset = %d, want %d", i, b.Offset, total)
+		}
+		total += int64(b.Size)
+	}
+	if total != int64(len(data)) {
+		t.Fatalf("blocks cover %d bytes, want %d", total, len(data))
+	}
+}
+
+func TestCDCChunkerAverageSize(t *testing.T) {
+	blockSize := 8 * 1024
+	data := randomContent(20 * 1024 * 1024)
+
+	blocks, err := newCDCChunker(blockSize).Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+
+	// Drop the final chunk: it ends when the input runs out rather than on
+	// a cut point, so it skews the mean low regardless of blockSize.
+	if len(blocks) < 2 {
+		t.Fatalf("got only %d blocks, need several to estimate a mean", len(blocks))
+	}
+	complete := blocks[:len(blocks)-1]
+
+	var total int64
+	for _, b := range complete {
+		total += int64(b.Size)
+	}
+	mean := total / int64(len(complete))
+
+	// The cut-point mask only targets blockSize on average; allow a wide
+	// band either side rather than pinning an exact value.
+	if mean < int64(blockSize)/2 || mean > int64(blockSize)*2 {
+		t.Errorf("mean chunk size = %d, want roughly %d (within 2x)", mean, blockSize)
+	}
+}
+
+func TestCDCChunkerRespectsBounds(t *testing.T) {
+	blockSize := 8 * 1024
+	data := randomContent(200 * 1024)
+
+	c := newCDCChunker(blockSize)
+	blocks, err := c.Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+
+	for i, b := range blocks {
+		last := i == len(blocks)-1
+		if int(b.Size) > c.maxSize {
+			t.Errorf("block %d size %d exceeds maxSize %d", i, b.Size, c.maxSize)
+		}
+		// Only the final chunk is allowed to be shorter than minSize, since
+		// it ends when the input runs out rather than on a cut point.
+		if !last && int(b.Size) < c.minSize {
+			t.Errorf("block %d size %d is under minSize %d", i, b.Size, c.minSize)
+		}
+	}
+}
+
+func TestCDCChunkerDeterministic(t *testing.T) {
+	data := randomContent(200 * 1024)
+
+	a, err := newCDCChunker(8 * 1024).Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	b, err := newCDCChunker(8 * 1024).Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+
+	if len(a) != len(b) {
+		t.Fatalf("got %d and %d blocks for identical input", len(a), len(b))
+	}
+	for i := range a {
+		if a[i].Offset != b[i].Offset || a[i].Size != b[i].Size || !bytes.Equal(a[i].Hash, b[i].Hash) {
+			t.Fatalf("block %d differs between identical runs: %+v vs %+v", i, a[i], b[i])
+		}
+	}
+}
+
+func TestCDCChunkerLocalEdit(t *testing.T) {
+	blockSize := 8 * 1024
+	data := randomContent(200 * 1024)
+
+	edited := append([]byte(nil), data...)
+	// Flip a single byte in the middle; everything before the chunk it
+	// falls in, and everything in later chunks, should re-cut identically
+	// -- that's the whole point of content-defined chunking over fixed-size
+	// blocking.
+	edited[100*1024] ^= 0xff
+
+	before, err := newCDCChunker(blockSize).Chunks(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	after, err := newCDCChunker(blockSize).Chunks(bytes.NewReader(edited), 0)
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+
+	// Count how many of the leading chunks (before the edit) are byte-for-
+	// byte identical; a fixed-size blocker would instead shift every chunk
+	// after the edit point.
+	var unchangedPrefix int
+	for unchangedPrefix < len(before) && unchangedPrefix < len(after) &&
+		bytes.Equal(before[unchangedPrefix].Hash, after[unchangedPrefix].Hash) {
+		unchangedPrefix++
+	}
+	if unchangedPrefix == 0 {
+		t.Error("expected at least the first chunk to be unaffected by a later edit")
+	}
+
+	// And the final chunk -- past the end of the edited region -- should
+	// also realign, rather than every chunk after the edit differing.
+	if !bytes.Equal(before[len(before)-1].Hash, after[len(after)-1].Hash) {
+		t.Error("expected the final chunk to realign after the edit")
+	}
+}
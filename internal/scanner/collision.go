@@ -0,0 +1,111 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// CollisionPolicy selects how Walker reacts when two names within the same
+// directory fold to the same case-insensitive, NFC-normalized key -- the
+// case HFS+, APFS (default config), NTFS and exFAT all collapse names like
+// "Foo.txt" and "foo.txt", or NFC/NFD-equivalent spellings of "café", into
+// a single directory entry, but Linux does not.
+type CollisionPolicy int
+
+const (
+	// CollisionSkip drops every name but the first in a colliding group,
+	// as if the rest didn't exist.
+	CollisionSkip CollisionPolicy = iota
+	// CollisionFirstWins keeps the first name in a colliding group as the
+	// syncable entry and reports the rest with FlagNameCollision instead
+	// of scanning them, so the model can quarantine or refuse the folder.
+	CollisionFirstWins
+	// CollisionReport reports every name in a colliding group, including
+	// the first, with FlagNameCollision, leaving the decision entirely to
+	// the model.
+	CollisionReport
+)
+
+// FlagNameCollision marks a FileInfo whose Name collides, under
+// case-insensitive or NFC/NFD-equivalent folding, with another entry in
+// the same directory.
+const FlagNameCollision uint32 = 1 << 20
+
+// dirCollisions answers, for a path being scanned, whether another entry
+// in the same directory folds to the same case-insensitive, NFC-normalized
+// key. Unlike building the set up incrementally as the walk visits entries,
+// it reads each directory's full listing up front (and caches the result),
+// so the very first entry of a colliding group is identified as such just
+// like the rest -- not only the ones visited after it.
+type dirCollisions struct {
+	fs    Filesystem
+	base  string
+	cache map[string]map[string][]string // dir -> folded key -> real names, in ReadDir order
+}
+
+func newDirCollisions(fs Filesystem, base string) *dirCollisions {
+	return &dirCollisions{fs: fs, base: base, cache: make(map[string]map[string][]string)}
+}
+
+func collisionKey(name string) string {
+	return norm.NFC.String(strings.ToLower(name))
+}
+
+func (d *dirCollisions) groups(dir string) map[string][]string {
+	if g, ok := d.cache[dir]; ok {
+		return g
+	}
+
+	g := make(map[string][]string)
+	if entries, err := d.fs.ReadDir(filepath.Join(d.base, dir)); err == nil {
+		for _, e := range entries {
+			key := collisionKey(e.Name())
+			g[key] = append(g[key], e.Name())
+		}
+	}
+	d.cache[dir] = g
+	return g
+}
+
+// check reports the other real names in rn's directory that fold to the
+// same key as rn, if any.
+func (d *dirCollisions) check(rn string) (others []string, collided bool) {
+	base := filepath.Base(rn)
+	group := d.groups(filepath.Dir(rn))[collisionKey(base)]
+	if len(group) < 2 {
+		return nil, false
+	}
+
+	for _, n := range group {
+		if n != base {
+			others = append(others, n)
+		}
+	}
+	return others, true
+}
+
+// isFirst reports whether rn is the first name, in ReadDir order, among
+// the entries it collides with -- the one CollisionSkip and
+// CollisionFirstWins keep as the syncable entry.
+func (d *dirCollisions) isFirst(rn string) bool {
+	base := filepath.Base(rn)
+	group := d.groups(filepath.Dir(rn))[collisionKey(base)]
+	return len(group) == 0 || group[0] == base
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDirCollisionsCaseInsensitive(t *testing.T) {
+	fs := NewMemFilesystem()
+	fs.AddFile("Foo.txt", 0644, time.Now(), []byte("a"))
+	fs.AddFile("foo.txt", 0644, time.Now(), []byte("b"))
+	fs.AddFile("bar.txt", 0644, time.Now(), []byte("c"))
+
+	dc := newDirCollisions(fs, ".")
+
+	others, collided := dc.check("Foo.txt")
+	if !collided || len(others) != 1 || others[0] != "foo.txt" {
+		t.Fatalf("check(Foo.txt) = %v, %v, want [foo.txt], true", others, collided)
+	}
+	if !dc.isFirst("Foo.txt") {
+		t.Error("Foo.txt should be the first entry, sorting before foo.txt in ReadDir order")
+	}
+	if dc.isFirst("foo.txt") {
+		t.Error("foo.txt should not be the first entry")
+	}
+
+	if _, collided := dc.check("bar.txt"); collided {
+		t.Error("bar.txt has no collision and should report collided == false")
+	}
+}
+
+func TestDirCollisionsNFCNFD(t *testing.T) {
+	nfc := "caf\u00e9.txt"  // NFC: U+00E9 LATIN SMALL LETTER E WITH ACUTE
+	nfd := "cafe\u0301.txt" // NFD: e + U+0301 COMBINING ACUTE ACCENT
+
+	fs := NewMemFilesystem()
+	fs.AddFile(nfc, 0644, time.Now(), []byte("a"))
+	fs.AddFile(nfd, 0644, time.Now(), []byte("b"))
+
+	dc := newDirCollisions(fs, ".")
+
+	others, collided := dc.check(nfd)
+	if !collided || len(others) != 1 || others[0] != nfc {
+		t.Fatalf("check(nfd) = %v, %v, want [%q], true", others, collided, nfc)
+	}
+	if !dc.isFirst(nfd) {
+		t.Error("the NFD spelling sorts first in ReadDir order and should be reported as such")
+	}
+}
+
+func TestDirCollisionsPerDirectory(t *testing.T) {
+	fs := NewMemFilesystem()
+	fs.AddDir("a", 0755)
+	fs.AddDir("b", 0755)
+	fs.AddFile("a/Foo.txt", 0644, time.Now(), []byte("a"))
+	fs.AddFile("b/foo.txt", 0644, time.Now(), []byte("b"))
+
+	dc := newDirCollisions(fs, ".")
+
+	if _, collided := dc.check("a/Foo.txt"); collided {
+		t.Error("entries in different directories must not collide with each other")
+	}
+	if _, collided := dc.check("b/foo.txt"); collided {
+		t.Error("entries in different directories must not collide with each other")
+	}
+}
+
+func TestWalkCollisionPolicies(t *testing.T) {
+	newFS := func() *MemFilesystem {
+		fs := NewMemFilesystem()
+		fs.AddFile("Foo.txt", 0644, time.Now(), []byte("a"))
+		fs.AddFile("foo.txt", 0644, time.Now(), []byte("b"))
+		fs.AddFile("bar.txt", 0644, time.Now(), []byte("c"))
+		return fs
+	}
+
+	cases := []struct {
+		policy         CollisionPolicy
+		wantNames      []string
+		wantCollisions map[string]bool
+	}{
+		{
+			policy:         CollisionSkip,
+			wantNames:      []string{"Foo.txt", "bar.txt"},
+			wantCollisions: map[string]bool{},
+		},
+		{
+			policy:         CollisionFirstWins,
+			wantNames:      []string{"Foo.txt", "foo.txt", "bar.txt"},
+			wantCollisions: map[string]bool{"foo.txt": true},
+		},
+		{
+			policy:         CollisionReport,
+			wantNames:      []string{"Foo.txt", "foo.txt", "bar.txt"},
+			wantCollisions: map[string]bool{"Foo.txt": true, "foo.txt": true},
+		},
+	}
+
+	for _, tc := range cases {
+		w := &Walker{Dir: ".", FS: newFS(), OnCollision: tc.policy}
+		out, err := w.Walk()
+		if err != nil {
+			t.Fatalf("policy %v: Walk: %v", tc.policy, err)
+		}
+
+		got := make(map[string]bool)
+		for f := range out {
+			got[f.Name] = f.Flags&FlagNameCollision != 0
+		}
+
+		if len(got) != len(tc.wantNames) {
+			t.Errorf("policy %v: got %d files, want %d (%v)", tc.policy, len(got), len(tc.wantNames), got)
+		}
+		for _, name := range tc.wantNames {
+			collided, ok := got[name]
+			if !ok {
+				t.Errorf("policy %v: missing %q", tc.policy, name)
+				continue
+			}
+			if collided != tc.wantCollisions[name] {
+				t.Errorf("policy %v: %q FlagNameCollision = %v, want %v", tc.policy, name, collided, tc.wantCollisions[name])
+			}
+		}
+	}
+}
+
+func TestCheckDirMem(t *testing.T) {
+	fs := NewMemFilesystem()
+	if err := checkDir(fs, "."); err != nil {
+		t.Fatalf("checkDir(.) = %v, want nil", err)
+	}
+
+	fs.AddFile("plain.txt", 0644, time.Now(), []byte("x"))
+	if err := checkDir(fs, "plain.txt"); err == nil {
+		t.Error("checkDir on a regular file should report an error")
+	}
+
+	if err := checkDir(fs, "missing"); err == nil {
+		t.Error("checkDir on a missing path should report an error")
+	} else if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("checkDir missing path error = %T, want *os.PathError", err)
+	}
+}
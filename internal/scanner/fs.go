@@ -0,0 +1,58 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Filesystem is the storage backend Walker scans. Dir and Sub are paths
+// within whatever namespace the Filesystem implements; they need not be
+// POSIX paths on disk. defaultFilesystem (an OS-backed implementation) is
+// used when a Walker's FS field is left nil, so existing callers that
+// scan the local disk don't need to change.
+//
+// All of the filtering Walker already does -- .stignore/.stfolder/
+// .stversions, TempNamer, Matcher, the NFC check and symlink handling --
+// stays in walkAndHashFiles, above this interface, so it applies
+// uniformly regardless of which Filesystem is in use.
+type Filesystem interface {
+	// Lstat returns file info for name, without following a trailing
+	// symlink.
+	Lstat(name string) (os.FileInfo, error)
+	// Open returns a reader over the plaintext contents of name.
+	Open(name string) (io.ReadCloser, error)
+	// ReadDir lists the entries of the directory name.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Readlink returns the target of the symlink name.
+	Readlink(name string) (string, error)
+	// Walk calls walkFn for every entry in the tree rooted at root, in the
+	// same manner as filepath.Walk.
+	Walk(root string, walkFn filepath.WalkFunc) error
+}
+
+// defaultFilesystem is used by Walker when FS is nil.
+var defaultFilesystem Filesystem = osFilesystem{}
+
+// fs returns w.FS, or defaultFilesystem if none was set.
+func (w *Walker) fs() Filesystem {
+	if w.FS != nil {
+		return w.FS
+	}
+	return defaultFilesystem
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// EncryptedFilesystem wraps another Filesystem, transparently decrypting
+// file contents as Walker reads them for hashing. Every regular file on
+// Underlying is expected to hold a random AES-CTR nonce followed by its
+// ciphertext; Lstat and ReadDir report the plaintext size so the FileInfo
+// Walker builds is independent of the ciphertext layout.
+type EncryptedFilesystem struct {
+	Underlying Filesystem
+	Key        [32]byte // AES-256 key, shared out of band
+}
+
+func (fs *EncryptedFilesystem) block() cipher.Block {
+	b, err := aes.NewCipher(fs.Key[:])
+	if err != nil {
+		// Key is fixed at 32 bytes, so this can't happen.
+		panic(err)
+	}
+	return b
+}
+
+func (fs *EncryptedFilesystem) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Underlying.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.maskSize(info), nil
+}
+
+func (fs *EncryptedFilesystem) Open(name string) (io.ReadCloser, error) {
+	r, err := fs.Underlying.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return &decryptingReader{r: r, stream: cipher.NewCTR(fs.block(), nonce)}, nil
+}
+
+func (fs *EncryptedFilesystem) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := fs.Underlying.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]os.FileInfo, len(infos))
+	for i, info := range infos {
+		out[i] = fs.maskSize(info)
+	}
+	return out, nil
+}
+
+func (fs *EncryptedFilesystem) Readlink(name string) (string, error) {
+	return fs.Underlying.Readlink(name)
+}
+
+func (fs *EncryptedFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.Underlying.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.maskSize(info)
+		}
+		return walkFn(p, info, err)
+	})
+}
+
+// maskSize hides the nonce prefix from regular files' reported size, so
+// that the plaintext length is what ends up in protocol.FileInfo. Symlinks
+// and directories carry no ciphertext and pass through unchanged.
+func (fs *EncryptedFilesystem) maskSize(info os.FileInfo) os.FileInfo {
+	if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		return info
+	}
+	return encryptedFileInfo{info}
+}
+
+type encryptedFileInfo struct {
+	os.FileInfo
+}
+
+func (fi encryptedFileInfo) Size() int64 {
+	if n := fi.FileInfo.Size() - aes.BlockSize; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// decryptingReader streams AES-CTR decrypted bytes from an underlying
+// ciphertext reader.
+type decryptingReader struct {
+	r      io.ReadCloser
+	stream cipher.Stream
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+func (d *decryptingReader) Close() error {
+	return d.r.Close()
+}
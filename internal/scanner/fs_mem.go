@@ -0,0 +1,193 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFilesystem is an in-memory Filesystem, for use in tests that want to
+// exercise Walker without touching the local disk.
+type MemFilesystem struct {
+	mut     sync.Mutex
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+	link    string // symlink target; entry is a symlink when non-empty
+}
+
+// NewMemFilesystem returns an empty MemFilesystem, containing only its
+// root directory ".".
+func NewMemFilesystem() *MemFilesystem {
+	return &MemFilesystem{
+		entries: map[string]*memEntry{
+			".": {mode: os.ModeDir | 0755},
+		},
+	}
+}
+
+func (fs *MemFilesystem) clean(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// AddFile adds or replaces a regular file at name with the given contents,
+// mode and modification time.
+func (fs *MemFilesystem) AddFile(name string, mode os.FileMode, modTime time.Time, data []byte) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	fs.entries[fs.clean(name)] = &memEntry{data: data, mode: mode, modTime: modTime}
+}
+
+// AddDir adds or replaces a directory at name.
+func (fs *MemFilesystem) AddDir(name string, mode os.FileMode) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	fs.entries[fs.clean(name)] = &memEntry{mode: mode | os.ModeDir}
+}
+
+// AddSymlink adds or replaces a symlink at name pointing at target.
+func (fs *MemFilesystem) AddSymlink(name, target string) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	fs.entries[fs.clean(name)] = &memEntry{mode: os.ModeSymlink | 0777, link: target}
+}
+
+func (fs *MemFilesystem) Lstat(name string) (os.FileInfo, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	e, ok := fs.entries[fs.clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	return memFileInfo{name: path.Base(fs.clean(name)), entry: e}, nil
+}
+
+func (fs *MemFilesystem) Open(name string) (io.ReadCloser, error) {
+	fs.mut.Lock()
+	e, ok := fs.entries[fs.clean(name)]
+	fs.mut.Unlock()
+	if !ok || e.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+func (fs *MemFilesystem) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	dir := fs.clean(name)
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	var infos []os.FileInfo
+	for p, e := range fs.entries {
+		if p == dir || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if strings.Contains(rest, "/") {
+			continue // not a direct child
+		}
+		infos = append(infos, memFileInfo{name: rest, entry: e})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs *MemFilesystem) Readlink(name string) (string, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	e, ok := fs.entries[fs.clean(name)]
+	if !ok || e.link == "" {
+		return "", errors.New("not a symlink")
+	}
+	return e.link, nil
+}
+
+func (fs *MemFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	root = fs.clean(root)
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	if err := fs.walk(root, info, walkFn); err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
+func (fs *MemFilesystem) walk(p string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	err := walkFn(p, info, nil)
+	if !info.IsDir() {
+		return err
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	children, err := fs.ReadDir(p)
+	if err != nil {
+		return walkFn(p, info, err)
+	}
+	for _, c := range children {
+		cp := path.Join(p, c.Name())
+		if err := fs.walk(cp, c, walkFn); err != nil {
+			if err == filepath.SkipDir {
+				if c.IsDir() {
+					continue
+				}
+				// As in filepath.Walk, SkipDir returned for a file skips
+				// the rest of the directory containing it.
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+type memFileInfo struct {
+	name  string
+	entry *memEntry
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.entry.data)) }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.entry.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.entry.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.entry.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,54 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// osFilesystem is the Filesystem implementation backed by the local disk.
+// It is what every Walker used before Filesystem existed, and remains the
+// default when FS is left nil.
+type osFilesystem struct{}
+
+func (osFilesystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFilesystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFilesystem) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (osFilesystem) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
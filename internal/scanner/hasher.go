@@ -0,0 +1,89 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/syncthing/syncthing/internal/protocol"
+)
+
+// newParallelHasher reads FileInfos without Blocks from in, fills in their
+// Blocks across workers goroutines, and sends the completed FileInfos to
+// out. Regular files are blocked according to chunking: FixedSize uses
+// Blocks() as always, ContentDefined runs the FastCDC-style chunker from
+// cdc.go instead, so that an edit in the middle of a large file only
+// invalidates the chunks touching it.
+func newParallelHasher(fs Filesystem, dir string, blockSize, workers int, out chan<- protocol.FileInfo, in <-chan protocol.FileInfo, chunking ChunkingMode) {
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for f := range in {
+				hashFile(fs, dir, blockSize, chunking, &f)
+				out <- f
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
+
+// hashFile fills in f.Blocks by reading it from fs and chunking its
+// content. Directories, symlinks and deletions already carry whatever
+// Blocks they need (or none) by the time they reach here, so they pass
+// through untouched.
+func hashFile(fs Filesystem, dir string, blockSize int, chunking ChunkingMode, f *protocol.FileInfo) {
+	if f.IsDirectory() || f.IsSymlink() || f.IsDeleted() {
+		return
+	}
+
+	r, err := fs.Open(filepath.Join(dir, f.Name))
+	if err != nil {
+		if debug {
+			l.Debugln("hash open error:", f.Name, err)
+		}
+		return
+	}
+	defer r.Close()
+
+	if chunking == ContentDefined {
+		blocks, err := newCDCChunker(blockSize).Chunks(r, 0)
+		if err != nil {
+			if debug {
+				l.Debugln("cdc error:", f.Name, err)
+			}
+			return
+		}
+		f.Blocks = blocks
+		return
+	}
+
+	blocks, err := Blocks(r, blockSize, 0)
+	if err != nil {
+		if debug {
+			l.Debugln("hash error:", f.Name, err)
+		}
+		return
+	}
+	f.Blocks = blocks
+}
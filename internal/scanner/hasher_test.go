@@ -0,0 +1,78 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/protocol"
+)
+
+func TestHashFileChunkingMode(t *testing.T) {
+	fs := NewMemFilesystem()
+	fs.AddFile("big.bin", 0644, time.Now(), randomContent(200*1024))
+
+	blockSize := 8 * 1024
+
+	var fixed protocol.FileInfo
+	fixed.Name = "big.bin"
+	hashFile(fs, ".", blockSize, FixedSize, &fixed)
+	if len(fixed.Blocks) == 0 {
+		t.Fatal("FixedSize hashing produced no blocks")
+	}
+	for i, b := range fixed.Blocks[:len(fixed.Blocks)-1] {
+		if int(b.Size) != blockSize {
+			t.Errorf("FixedSize block %d has size %d, want %d", i, b.Size, blockSize)
+		}
+	}
+
+	var cdc protocol.FileInfo
+	cdc.Name = "big.bin"
+	hashFile(fs, ".", blockSize, ContentDefined, &cdc)
+	if len(cdc.Blocks) == 0 {
+		t.Fatal("ContentDefined hashing produced no blocks")
+	}
+
+	// The two chunkers place their cuts differently; a file large enough
+	// to span several blocks shouldn't happen to produce the identical
+	// block count and sizes under both.
+	sameShape := len(cdc.Blocks) == len(fixed.Blocks)
+	for i := 0; sameShape && i < len(cdc.Blocks); i++ {
+		if cdc.Blocks[i].Size != fixed.Blocks[i].Size {
+			sameShape = false
+		}
+	}
+	if sameShape {
+		t.Error("ContentDefined and FixedSize produced identically-shaped blocks; chunker may not be wired in")
+	}
+}
+
+func TestHashFileSkipsNonRegular(t *testing.T) {
+	fs := NewMemFilesystem()
+
+	dir := protocol.FileInfo{Name: "adir", Flags: protocol.FlagDirectory}
+	hashFile(fs, ".", 1024, FixedSize, &dir)
+	if dir.Blocks != nil {
+		t.Error("hashFile should not touch a directory's Blocks")
+	}
+
+	deleted := protocol.FileInfo{Name: "gone.txt", Flags: protocol.FlagDeleted}
+	hashFile(fs, ".", 1024, FixedSize, &deleted)
+	if deleted.Blocks != nil {
+		t.Error("hashFile should not touch a deleted entry's Blocks")
+	}
+}
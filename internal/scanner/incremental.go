@@ -0,0 +1,209 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/syncthing/syncthing/internal/lamport"
+	"github.com/syncthing/syncthing/internal/protocol"
+)
+
+// debounceInterval is how long WalkIncremental waits for a path to go quiet
+// before re-hashing it, so that a burst of writes to the same file (a large
+// copy, an editor doing save-as-rename) results in one re-hash instead of
+// many. It's a var, rather than a const, so tests can shorten it.
+var debounceInterval = 500 * time.Millisecond
+
+// WalkIncremental consumes FSEvents, typically produced by a Notifier, and
+// re-hashes only the paths they name instead of performing a full Walk. It
+// applies the same TempNamer/Matcher/CurrentFiler based filtering as Walk,
+// so the two entry points always agree on what is syncable. Directory
+// creation events are expanded into a sub-walk of just that directory, so
+// new files appear without rescanning the whole folder. The returned
+// channel is closed when ctx is cancelled or events is closed.
+func (w *Walker) WalkIncremental(ctx context.Context, events <-chan FSEvent) (chan protocol.FileInfo, error) {
+	if err := checkDir(w.fs(), w.Dir); err != nil {
+		return nil, err
+	}
+
+	if debug {
+		l.Debugln("WalkIncremental", w.Dir, w.Sub, w.BlockSize, w.Matcher)
+	}
+
+	files := make(chan protocol.FileInfo)
+	hashedFiles := make(chan protocol.FileInfo)
+	out := make(chan protocol.FileInfo)
+	newParallelHasher(w.fs(), w.Dir, w.BlockSize, runtime.NumCPU(), hashedFiles, files, w.Chunking)
+
+	go func() {
+		defer close(files)
+		w.debounce(ctx, events, func(rn string) {
+			w.handleIncrementalEvent(rn, files)
+		})
+	}()
+
+	go func() {
+		// Merge results into the baseline snapshot, the same way Walk does,
+		// so that a file created or deleted purely through incremental
+		// events is reflected the next time it's looked up -- notably by
+		// emitDeleted's no-CurrentFiler fallback below.
+		for f := range hashedFiles {
+			w.mut.Lock()
+			if w.baseline == nil {
+				w.baseline = make(map[string]protocol.FileInfo)
+			}
+			if f.IsDeleted() {
+				delete(w.baseline, f.Name)
+			} else {
+				w.baseline[f.Name] = f
+			}
+			w.mut.Unlock()
+			out <- f
+		}
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// debounce reads events until ctx is cancelled or events is closed,
+// coalescing rapid repeats for the same path and calling fire(rn) once the
+// path has been quiet for debounceInterval. It does not return until every
+// fire(rn) it started has also returned, so a caller that closes the
+// channel fire sends on right after debounce returns can't race one of
+// those calls.
+func (w *Walker) debounce(ctx context.Context, events <-chan FSEvent, fire func(rn string)) {
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+	timers := make(map[string]*time.Timer)
+	defer func() {
+		mut.Lock()
+		for _, t := range timers {
+			if t.Stop() {
+				// Its callback will never run to call wg.Done.
+				wg.Done()
+			}
+		}
+		mut.Unlock()
+		// Stop only prevents timers that haven't fired yet from doing so;
+		// one that already fired and is mid-callback removes itself from
+		// timers before calling fire, so it's invisible to the sweep above.
+		// Waiting for wg here is what actually closes that gap.
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if w.shouldIgnore(ev.Name) {
+				if debug {
+					l.Debugln("incremental: ignored event:", ev.Name)
+				}
+				continue
+			}
+
+			mut.Lock()
+			if t, ok := timers[ev.Name]; ok {
+				t.Reset(debounceInterval)
+			} else {
+				rn := ev.Name
+				wg.Add(1)
+				timers[rn] = time.AfterFunc(debounceInterval, func() {
+					defer wg.Done()
+					mut.Lock()
+					delete(timers, rn)
+					mut.Unlock()
+					fire(rn)
+				})
+			}
+			mut.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleIncrementalEvent re-stats rn and emits a FileInfo reflecting its
+// current state: a deletion if it's gone, a sub-walk if it's now a
+// directory, or a single re-hash otherwise. It reuses walkAndHashFiles so
+// the filtering and flag logic is identical to a full Walk.
+func (w *Walker) handleIncrementalEvent(rn string, fchan chan protocol.FileInfo) {
+	p := filepath.Join(w.Dir, rn)
+	hashFiles := w.walkAndHashFiles(fchan)
+
+	info, err := w.fs().Lstat(p)
+	if err != nil {
+		w.emitDeleted(rn, fchan)
+		return
+	}
+
+	if info.IsDir() {
+		// Collapse the creation (or permission change) of a directory into
+		// a sub-walk of just that directory, rather than waiting for the
+		// next full Walk to discover what's inside it.
+		w.fs().Walk(p, hashFiles)
+		return
+	}
+
+	hashFiles(p, info, nil)
+}
+
+// emitDeleted reports rn as deleted if we previously knew about it, via
+// CurrentFiler if one is configured, falling back to the last full Walk's
+// baseline snapshot otherwise.
+func (w *Walker) emitDeleted(rn string, fchan chan protocol.FileInfo) {
+	var prev protocol.FileInfo
+	var known bool
+
+	if w.CurrentFiler != nil {
+		if cf := w.CurrentFiler.CurrentFile(rn); !cf.IsDeleted() {
+			prev, known = cf, true
+		}
+	} else {
+		w.mut.Lock()
+		f, ok := w.baseline[rn]
+		w.mut.Unlock()
+		if ok {
+			prev, known = f, true
+		}
+	}
+
+	if !known {
+		return
+	}
+
+	if debug {
+		l.Debugln("incremental: deleted:", rn)
+	}
+
+	fchan <- protocol.FileInfo{
+		Name:     rn,
+		Version:  lamport.Default.Tick(prev.Version),
+		Flags:    prev.Flags | protocol.FlagDeleted,
+		Modified: prev.Modified,
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/syncthing/syncthing/internal/lamport"
+	"github.com/syncthing/syncthing/internal/protocol"
+)
+
+// withShortDebounce temporarily shortens debounceInterval for the duration
+// of a test, restoring it afterwards.
+func withShortDebounce(t *testing.T, d time.Duration) {
+	orig := debounceInterval
+	debounceInterval = d
+	t.Cleanup(func() { debounceInterval = orig })
+}
+
+func TestDebounceCoalescesBursts(t *testing.T) {
+	withShortDebounce(t, 20*time.Millisecond)
+
+	w := &Walker{Dir: "."}
+	events := make(chan FSEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mut sync.Mutex
+	fired := make(map[string]int)
+	done := make(chan struct{})
+	go func() {
+		w.debounce(ctx, events, func(rn string) {
+			mut.Lock()
+			fired[rn]++
+			mut.Unlock()
+		})
+		close(done)
+	}()
+
+	// A burst of five events for the same path, faster than the debounce
+	// interval, should coalesce into a single fire.
+	for i := 0; i < 5; i++ {
+		events <- FSEvent{Name: "foo.txt", Op: EventWrite}
+		time.Sleep(2 * time.Millisecond)
+	}
+	// A second, unrelated path should fire independently.
+	events <- FSEvent{Name: "bar.txt", Op: EventWrite}
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	mut.Lock()
+	defer mut.Unlock()
+	if fired["foo.txt"] != 1 {
+		t.Errorf("foo.txt fired %d times, want 1", fired["foo.txt"])
+	}
+	if fired["bar.txt"] != 1 {
+		t.Errorf("bar.txt fired %d times, want 1", fired["bar.txt"])
+	}
+}
+
+func TestDebounceIgnoresMatchedPaths(t *testing.T) {
+	withShortDebounce(t, 10*time.Millisecond)
+
+	w := &Walker{Dir: "."}
+	events := make(chan FSEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fired := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		w.debounce(ctx, events, func(rn string) { fired <- rn })
+		close(done)
+	}()
+
+	events <- FSEvent{Name: ".stfolder", Op: EventWrite}
+	events <- FSEvent{Name: "foo.txt", Op: EventWrite}
+
+	select {
+	case rn := <-fired:
+		if rn != "foo.txt" {
+			t.Errorf("fired for %q, want foo.txt", rn)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for debounce to fire")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestEmitDeletedFallsBackToBaseline(t *testing.T) {
+	w := &Walker{Dir: "."}
+	w.baseline = map[string]protocol.FileInfo{
+		"foo.txt": {Name: "foo.txt", Version: lamport.Default.Tick(0), Modified: 1234},
+	}
+
+	fchan := make(chan protocol.FileInfo, 1)
+	w.emitDeleted("foo.txt", fchan)
+
+	select {
+	case f := <-fchan:
+		if f.Name != "foo.txt" || !f.IsDeleted() || f.Modified != 1234 {
+			t.Errorf("emitDeleted produced %+v", f)
+		}
+	default:
+		t.Fatal("emitDeleted did not emit anything for a known, baseline-only file")
+	}
+
+	fchan2 := make(chan protocol.FileInfo, 1)
+	w.emitDeleted("never-seen.txt", fchan2)
+	select {
+	case f := <-fchan2:
+		t.Errorf("emitDeleted emitted %+v for a name never seen before", f)
+	default:
+	}
+}
+
+func TestWalkIncrementalUpdatesBaseline(t *testing.T) {
+	withShortDebounce(t, 10*time.Millisecond)
+
+	fs := NewMemFilesystem()
+	w := &Walker{Dir: ".", FS: fs}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan FSEvent)
+	out, err := w.WalkIncremental(ctx, events)
+	if err != nil {
+		t.Fatalf("WalkIncremental: %v", err)
+	}
+
+	fs.AddFile("new.txt", 0644, time.Now(), []byte("hello"))
+	events <- FSEvent{Name: "new.txt", Op: EventWrite}
+
+	select {
+	case f := <-out:
+		if f.Name != "new.txt" {
+			t.Fatalf("got FileInfo for %q, want new.txt", f.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the created file to be hashed")
+	}
+
+	w.mut.Lock()
+	_, known := w.baseline["new.txt"]
+	w.mut.Unlock()
+	if !known {
+		t.Fatal("new.txt was hashed but never merged into w.baseline")
+	}
+
+	cancel()
+	close(events)
+}
+
+// TestDebounceWaitsForInFlightFires guards against a send-on-closed-channel
+// panic: a caller that closes the channel fire sends on as soon as debounce
+// returns must never race a timer callback that had already started firing
+// when ctx was cancelled.
+func TestDebounceWaitsForInFlightFires(t *testing.T) {
+	withShortDebounce(t, time.Millisecond)
+
+	w := &Walker{Dir: "."}
+	events := make(chan FSEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.debounce(ctx, events, func(rn string) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			// Simulate fire doing real work (handleIncrementalEvent hashing
+			// and sending to files) that outlives the debounce interval.
+			<-release
+		})
+	}()
+
+	events <- FSEvent{Name: "foo.txt", Op: EventWrite}
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the timer callback to start")
+	}
+
+	// Cancel while fire is still blocked inside the timer callback: debounce
+	// must not return (and so the caller must not close its channel) until
+	// that callback finishes.
+	cancel()
+
+	select {
+	case <-done:
+		t.Fatal("debounce returned while a fire call was still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("debounce never returned after the in-flight fire call completed")
+	}
+}
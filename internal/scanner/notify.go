@@ -0,0 +1,48 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+// EventOp describes the kind of change a Notifier observed.
+type EventOp int
+
+const (
+	// EventWrite covers both file creation and content modification; the
+	// incremental walker re-stats and re-hashes the path either way.
+	EventWrite EventOp = iota
+	EventRemove
+	EventRename
+)
+
+// FSEvent is a single filesystem change notification. Name is relative to
+// the Walker's Dir, using the same separators as protocol.FileInfo.Name.
+type FSEvent struct {
+	Name string
+	Op   EventOp
+}
+
+// Notifier watches a directory tree for changes and delivers them as
+// FSEvents. Implementations are platform specific; see notify_fsnotify.go
+// for the inotify/ReadDirectoryChangesW/FSEvents backed implementation used
+// on Linux, Windows and macOS, and notify_kqueue.go for the kqueue based
+// fallback used on the BSDs.
+type Notifier interface {
+	// Start begins watching root, which is an absolute path, and returns a
+	// channel of events. The channel is closed when Stop is called or the
+	// watch fails irrecoverably.
+	Start(root string) (<-chan FSEvent, error)
+	// Stop releases any resources associated with the watch.
+	Stop()
+}
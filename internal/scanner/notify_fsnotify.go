@@ -0,0 +1,122 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// +build linux windows darwin
+
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// fsNotifier is a Notifier backed by fsnotify, which in turn uses inotify on
+// Linux, ReadDirectoryChangesW on Windows and FSEvents on macOS.
+type fsNotifier struct {
+	watcher *fsnotify.Watcher
+	events  chan FSEvent
+	stop    chan struct{}
+}
+
+// NewNotifier returns the platform default Notifier implementation.
+func NewNotifier() (Notifier, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsNotifier{
+		watcher: watcher,
+		events:  make(chan FSEvent),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+func (n *fsNotifier) Start(root string) (<-chan FSEvent, error) {
+	if err := n.addDirs(root); err != nil {
+		return nil, err
+	}
+
+	go n.loop(root)
+
+	return n.events, nil
+}
+
+// addDirs registers a watch for root and every directory below it; fsnotify
+// does not support recursive watches natively, so we have to enumerate them
+// up front and add new ones as directories are created.
+func (n *fsNotifier) addDirs(root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if werr := n.watcher.Add(p); werr != nil && debug {
+				l.Debugln("notify: watch error:", p, werr)
+			}
+		}
+		return nil
+	})
+}
+
+func (n *fsNotifier) loop(root string) {
+	defer close(n.events)
+	for {
+		select {
+		case ev, ok := <-n.watcher.Events:
+			if !ok {
+				return
+			}
+
+			rn, err := filepath.Rel(root, ev.Name)
+			if err != nil {
+				continue
+			}
+
+			var op EventOp
+			switch {
+			case ev.Op&fsnotify.Remove == fsnotify.Remove || ev.Op&fsnotify.Rename == fsnotify.Rename:
+				op = EventRemove
+			default:
+				op = EventWrite
+				// A newly created entry needs to be watched itself, and so
+				// does everything below it: if a whole populated tree was
+				// moved in, fsnotify only reports the move of the top
+				// directory, so addDirs must recurse rather than watch
+				// just ev.Name.
+				if ev.Op&fsnotify.Create == fsnotify.Create {
+					if err := n.addDirs(ev.Name); err != nil && debug {
+						l.Debugln("notify: watch error:", ev.Name, err)
+					}
+				}
+			}
+
+			select {
+			case n.events <- FSEvent{Name: rn, Op: op}:
+			case <-n.stop:
+				return
+			}
+
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *fsNotifier) Stop() {
+	close(n.stop)
+	n.watcher.Close()
+}
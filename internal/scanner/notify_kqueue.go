@@ -0,0 +1,163 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+// more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd openbsd netbsd dragonfly
+
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// kqueueNotifier is the Notifier fallback used on the BSDs, where fsnotify's
+// kqueue backend only delivers events for the watched fds themselves, not
+// their contents. We therefore re-open every directory under root and hand
+// its fd to kqueue directly.
+type kqueueNotifier struct {
+	kq   int
+	fds  map[int]string
+	stop chan struct{}
+}
+
+// NewNotifier returns the platform default Notifier implementation.
+func NewNotifier() (Notifier, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	return &kqueueNotifier{
+		kq:   kq,
+		fds:  make(map[int]string),
+		stop: make(chan struct{}),
+	}, nil
+}
+
+func (n *kqueueNotifier) Start(root string) (<-chan FSEvent, error) {
+	events := make(chan FSEvent)
+
+	if err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		return n.watch(p)
+	}); err != nil {
+		return nil, err
+	}
+
+	go n.loop(root, events)
+
+	return events, nil
+}
+
+func (n *kqueueNotifier) watch(p string) error {
+	fd, err := syscall.Open(p, syscall.O_RDONLY, 0)
+	if err != nil {
+		if debug {
+			l.Debugln("notify: open error:", p, err)
+		}
+		return nil
+	}
+
+	n.fds[fd] = p
+
+	ev := syscall.Kevent_t{
+		Ident:  uint64(fd),
+		Filter: syscall.EVFILT_VNODE,
+		Flags:  syscall.EV_ADD | syscall.EV_CLEAR,
+		Fflags: syscall.NOTE_WRITE | syscall.NOTE_DELETE | syscall.NOTE_RENAME | syscall.NOTE_EXTEND,
+	}
+	_, err = syscall.Kevent(n.kq, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+func (n *kqueueNotifier) loop(root string, events chan<- FSEvent) {
+	defer close(events)
+	kevs := make([]syscall.Kevent_t, 16)
+	for {
+		select {
+		case <-n.stop:
+			return
+		default:
+		}
+
+		nev, err := syscall.Kevent(n.kq, nil, kevs, nil)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+
+		for _, ev := range kevs[:nev] {
+			p, ok := n.fds[int(ev.Ident)]
+			if !ok {
+				continue
+			}
+
+			rn, err := filepath.Rel(root, p)
+			if err != nil {
+				continue
+			}
+
+			op := EventWrite
+			if ev.Fflags&(syscall.NOTE_DELETE|syscall.NOTE_RENAME) != 0 {
+				op = EventRemove
+			} else {
+				// p is always a directory (watch() is only ever called on
+				// one), and NOTE_WRITE on a directory fd means its listing
+				// changed. Pick up any subdirectories that appeared in it
+				// -- including a whole tree moved in at once, since kqueue
+				// only reports the change on p itself -- so they get fds
+				// of their own instead of going permanently unwatched.
+				n.watchNew(p)
+			}
+
+			select {
+			case events <- FSEvent{Name: rn, Op: op}:
+			case <-n.stop:
+				return
+			}
+		}
+	}
+}
+
+// watchNew recurses into root and registers a watch for every directory
+// not already tracked in n.fds.
+func (n *kqueueNotifier) watchNew(root string) {
+	known := make(map[string]bool, len(n.fds))
+	for _, p := range n.fds {
+		known[p] = true
+	}
+
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || known[p] {
+			return nil
+		}
+		if werr := n.watch(p); werr != nil && debug {
+			l.Debugln("notify: watch error:", p, werr)
+		}
+		return nil
+	})
+}
+
+func (n *kqueueNotifier) Stop() {
+	close(n.stop)
+	for fd := range n.fds {
+		syscall.Close(fd)
+	}
+	syscall.Close(n.kq)
+}
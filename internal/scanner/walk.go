@@ -21,11 +21,11 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/syncthing/syncthing/internal/ignore"
 	"github.com/syncthing/syncthing/internal/lamport"
 	"github.com/syncthing/syncthing/internal/protocol"
-	"github.com/syncthing/syncthing/internal/symlinks"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -46,6 +46,25 @@ type Walker struct {
 	// detected. Scanned files will get zero permission bits and the
 	// NoPermissionBits flag set.
 	IgnorePerms bool
+	// Chunking selects fixed-size or content-defined blocking for regular
+	// files. It defaults to FixedSize, matching the historical behavior of
+	// Blocks().
+	Chunking ChunkingMode
+	// FS is the storage backend to scan. If nil, the local disk is used,
+	// via the os package.
+	FS Filesystem
+	// OnCollision selects how to react to names that a case-insensitive or
+	// NFC/NFD-equivalent filesystem would treat as one entry. The zero
+	// value, CollisionSkip, silently drops every name but the first, in
+	// ReadDir order, of each colliding group.
+	OnCollision CollisionPolicy
+
+	mut sync.Mutex
+	// baseline holds the result of the last full Walk, keyed by the same
+	// relative name used in protocol.FileInfo.Name. WalkIncremental
+	// merges its own results into it and, when CurrentFiler is nil,
+	// consults it to decide whether a vanished path is reported deleted.
+	baseline map[string]protocol.FileInfo
 }
 
 type TempNamer interface {
@@ -67,25 +86,42 @@ func (w *Walker) Walk() (chan protocol.FileInfo, error) {
 		l.Debugln("Walk", w.Dir, w.Sub, w.BlockSize, w.Matcher)
 	}
 
-	err := checkDir(w.Dir)
+	err := checkDir(w.fs(), w.Dir)
 	if err != nil {
 		return nil, err
 	}
 
 	files := make(chan protocol.FileInfo)
 	hashedFiles := make(chan protocol.FileInfo)
-	newParallelHasher(w.Dir, w.BlockSize, runtime.NumCPU(), hashedFiles, files)
+	out := make(chan protocol.FileInfo)
+	newParallelHasher(w.fs(), w.Dir, w.BlockSize, runtime.NumCPU(), hashedFiles, files, w.Chunking)
 
 	go func() {
 		hashFiles := w.walkAndHashFiles(files)
-		filepath.Walk(filepath.Join(w.Dir, w.Sub), hashFiles)
+		w.fs().Walk(filepath.Join(w.Dir, w.Sub), hashFiles)
 		close(files)
 	}()
 
-	return hashedFiles, nil
+	go func() {
+		// Record a baseline snapshot as the full scan completes, so that a
+		// later WalkIncremental has something to coalesce events against.
+		baseline := make(map[string]protocol.FileInfo)
+		for f := range hashedFiles {
+			baseline[f.Name] = f
+			out <- f
+		}
+		w.mut.Lock()
+		w.baseline = baseline
+		w.mut.Unlock()
+		close(out)
+	}()
+
+	return out, nil
 }
 
 func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFunc {
+	collisions := newDirCollisions(w.fs(), w.Dir)
+
 	return func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			if debug {
@@ -106,37 +142,63 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 			return nil
 		}
 
-		if w.TempNamer != nil && w.TempNamer.IsTemporary(rn) {
-			// A temporary file
-			if debug {
-				l.Debugln("temporary:", rn)
+		if w.shouldIgnore(rn) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		if sn := filepath.Base(rn); sn == ".stignore" || sn == ".stfolder" ||
-			strings.HasPrefix(rn, ".stversions") || (w.Matcher != nil && w.Matcher.Match(rn)) {
-			// An ignored file
-			if debug {
-				l.Debugln("ignored:", rn)
-			}
-			if info.IsDir() {
-				return filepath.SkipDir
+		// The collision check runs before the NFC-normalization check
+		// below, and on success pre-empts it entirely: an NFD-spelled name
+		// is first weighed against its NFC sibling here, rather than
+		// being dropped by the generic non-NFC warning before collision
+		// detection ever sees it.
+		others, collided := collisions.check(rn)
+		if collided {
+			first := collisions.isFirst(rn)
+
+			switch w.OnCollision {
+			case CollisionSkip:
+				if !first {
+					if debug {
+						l.Debugln("collision, skipping:", rn, "vs", others)
+					}
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+			case CollisionFirstWins, CollisionReport:
+				// FirstWins reports every name but the first; Report
+				// reports all of them, the first included.
+				if first && w.OnCollision == CollisionFirstWins {
+					break
+				}
+				l.Warnf("Name collision: %q is the same entry as %v on case-insensitive or Unicode-folding filesystems", rn, others)
+				f := protocol.FileInfo{
+					Name:     rn,
+					Version:  lamport.Default.Tick(0),
+					Flags:    FlagNameCollision,
+					Modified: info.ModTime().Unix(),
+				}
+				fchan <- f
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
 			}
-			return nil
 		}
 
-		if (runtime.GOOS == "linux" || runtime.GOOS == "windows") && !norm.NFC.IsNormalString(rn) {
+		if !collided && (runtime.GOOS == "linux" || runtime.GOOS == "windows") && !norm.NFC.IsNormalString(rn) {
 			l.Warnf("File %q contains non-NFC UTF-8 sequences and cannot be synced. Consider renaming.", rn)
 			return nil
 		}
 
-		// We must perform this check, as symlinks on Windows are always
-		// .IsRegular or .IsDir unlike on Unix.
 		// Index wise symlinks are always files, regardless of what the target
 		// is, because symlinks carry their target path as their content.
-		isSymlink, _ := symlinks.IsSymlink(p)
-		if isSymlink {
+		if info.Mode()&os.ModeSymlink != 0 {
 			var rval error
 			// If the target is a directory, do NOT descend down there.
 			// This will cause files to get tracked, and removing the symlink
@@ -151,19 +213,19 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 			// permissions.
 			// We check if they point to the old target by checking that
 			// their existing blocks match with the blocks in the index.
-			// If we don't have a filer or don't support symlinks, skip.
-			if w.CurrentFiler == nil || !symlinks.Supported {
+			// If we don't have a filer, skip.
+			if w.CurrentFiler == nil {
 				return rval
 			}
 
-			target, flags, err := symlinks.Read(p)
-			flags = flags & protocol.SymlinkTypeMask
+			target, err := w.fs().Readlink(p)
 			if err != nil {
 				if debug {
 					l.Debugln("readlink error:", p, err)
 				}
 				return rval
 			}
+			flags := symlinkTargetFlags(w.fs(), p, target)
 
 			blocks, err := Blocks(strings.NewReader(target), w.BlockSize, 0)
 			if err != nil {
@@ -240,6 +302,12 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 			if w.IgnorePerms {
 				flags = protocol.FlagNoPermBits | 0666
 			}
+			if w.Chunking == ContentDefined {
+				// The hasher picks the chunker from w.Chunking; peers
+				// consult this flag to know the Blocks they receive are
+				// variable size rather than fixed BlockSize chunks.
+				flags |= FlagContentDefinedChunks
+			}
 
 			f := protocol.FileInfo{
 				Name:     rn,
@@ -257,8 +325,34 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 	}
 }
 
-func checkDir(dir string) error {
-	if info, err := os.Lstat(dir); err != nil {
+// shouldIgnore reports whether rn (a path relative to w.Dir) should be
+// excluded from scanning, either because it names one of syncthing's own
+// bookkeeping paths, is a temporary file, or matches the user's ignore
+// patterns. WalkIncremental uses the same check so that events for ignored
+// paths never produce a FileInfo.
+func (w *Walker) shouldIgnore(rn string) bool {
+	if w.TempNamer != nil && w.TempNamer.IsTemporary(rn) {
+		// A temporary file
+		if debug {
+			l.Debugln("temporary:", rn)
+		}
+		return true
+	}
+
+	if sn := filepath.Base(rn); sn == ".stignore" || sn == ".stfolder" ||
+		strings.HasPrefix(rn, ".stversions") || (w.Matcher != nil && w.Matcher.Match(rn)) {
+		// An ignored file
+		if debug {
+			l.Debugln("ignored:", rn)
+		}
+		return true
+	}
+
+	return false
+}
+
+func checkDir(fs Filesystem, dir string) error {
+	if info, err := fs.Lstat(dir); err != nil {
 		return err
 	} else if !info.IsDir() {
 		return errors.New(dir + ": not a directory")
@@ -268,6 +362,26 @@ func checkDir(dir string) error {
 	return nil
 }
 
+// symlinkTargetFlags resolves target (as read from the symlink at p on fs)
+// and reports whether it's missing or names a directory, the two things
+// SymlinkTypeEqual compares peers on.
+func symlinkTargetFlags(fs Filesystem, p, target string) uint32 {
+	if target == "" {
+		return protocol.FlagSymlinkMissingTarget
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(p), target)
+	}
+	info, err := fs.Lstat(target)
+	if err != nil {
+		return protocol.FlagSymlinkMissingTarget
+	}
+	if info.IsDir() {
+		return protocol.FlagDirectory
+	}
+	return 0
+}
+
 func PermsEqual(a, b uint32) bool {
 	switch runtime.GOOS {
 	case "windows":